@@ -1,108 +1,174 @@
 package logging
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
-var (
-	standardFields logrus.Fields
-	logger         *logrus.Logger
+// Format selects the text encoding used when building a logger from Config.
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
 )
 
+// Config selects the level, format and output of a logger built with NewFromConfig.
+type Config struct {
+	Level  slog.Level
+	Format Format
+	Output io.Writer // defaults to os.Stdout when nil
+}
+
 func init() {
-	logger = logrus.New()
-	logger.Formatter = customFormatter{&logrus.TextFormatter{
-		TimestampFormat: time.RFC3339Nano,
-	}}
-	logger.Out = os.Stdout
+	slog.SetDefault(NewFromConfig(Config{Format: FormatText}))
+}
+
+// NewFromConfig builds a *slog.Logger from the given Config.
+func NewFromConfig(cfg Config) *slog.Logger {
+	out := cfg.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       cfg.Level,
+		ReplaceAttr: replaceTimeWithRFC3339Nano,
+	}
+
+	var handler slog.Handler
+	switch cfg.Format {
+	case FormatJSON:
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		handler = slog.NewTextHandler(out, opts)
+	}
+
+	return slog.New(handler)
+}
+
+// replaceTimeWithRFC3339Nano formats the built-in time attribute to match the
+// RFC3339Nano timestamps the old customFormatter used to emit.
+func replaceTimeWithRFC3339Nano(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey && len(groups) == 0 {
+		a.Value = slog.StringValue(a.Value.Time().Format(time.RFC3339Nano))
+	}
+	return a
+}
+
+// SetDefault installs logger as the package-level and slog default logger.
+func SetDefault(logger *slog.Logger) {
+	slog.SetDefault(logger)
 }
 
-// SetStandardFields sets up the service name, version, hostname and pid fields
+// SetStandardFields sets up the service name and version fields on the default logger
 func SetStandardFields(service, version string) {
 	//hostname, _ := os.Hostname()
-	standardFields = logrus.Fields{
-		"service": service,
-		"version": version,
-		//"hostname": hostname,
-	}
+	SetDefault(slog.Default().With(
+		"service", service,
+		"version", version,
+		//"hostname", hostname,
+	))
 
 	Info("Starting")
 }
 
 // UsePrettyPrint tells the logger to print in human readable format
 func UsePrettyPrint() {
-	logger.Formatter = customFormatter{&logrus.TextFormatter{
-		FullTimestamp:    true,
-		TimestampFormat:  time.RFC3339Nano,
-		QuoteEmptyFields: true,
-	}}
+	SetDefault(NewFromConfig(Config{Format: FormatText, Output: os.Stdout}))
+}
+
+type levelWriter struct {
+	level slog.Level
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	slog.Default().Log(context.Background(), w.level, strings.TrimRight(string(p), "\n"))
+	return len(p), nil
 }
 
 // WarnLogger creates a logger that can plug in to an HTTP server
 func WarnLogger() (basicLogger *log.Logger, dispose func()) {
-	w := logger.WriterLevel(logrus.WarnLevel)
-	basicLogger = log.New(w, "", 0)
-	dispose = func() {
-		w.Close()
-	}
+	basicLogger = log.New(levelWriter{slog.LevelWarn}, "", 0)
+	dispose = func() {}
 
 	return
 }
 
 // ErrorLogger creates a logger that can plug in to an HTTP server
 func ErrorLogger() (basicLogger *log.Logger, dispose func()) {
-	w := logger.WriterLevel(logrus.ErrorLevel)
-	basicLogger = log.New(w, "", 0)
-	dispose = func() {
-		w.Close()
-	}
+	basicLogger = log.New(levelWriter{slog.LevelError}, "", 0)
+	dispose = func() {}
 
 	return
 }
 
-func Debug(msg string) {
-	logger.Debug(msg)
+func Debug(msg string, args ...any) {
+	slog.Default().Debug(msg, args...)
 }
 
-func Info(msg string) {
-	logger.Info(msg)
+func Info(msg string, args ...any) {
+	slog.Default().Info(msg, args...)
 }
 
 func Infof(msg string, things ...any) {
-	logger.Infof(msg, things...)
+	slog.Default().Info(fmt.Sprintf(msg, things...))
 }
 
-func Warn(msg string) {
-	logger.Warn(msg)
+func Warn(msg string, args ...any) {
+	slog.Default().Warn(msg, args...)
 }
 
-func Error(msg string) {
-	logger.Error(msg)
+func Error(msg string, args ...any) {
+	slog.Default().Error(msg, args...)
 }
 
-func Fatal(msg string) {
-	logger.Fatal(msg)
+func Fatal(msg string, args ...any) {
+	slog.Default().Error(msg, args...)
+	os.Exit(1)
 }
 
-// WithField returns a logger with the supplied field added to the standard fields
-func WithField(key string, value any) *logrus.Entry {
-	return logger.WithField(key, value)
+// WithField returns a logger with the supplied key/value pair added
+func WithField(key string, value any) *slog.Logger {
+	return slog.Default().With(key, value)
 }
 
 // WithError returns a logger with the supplied error added to the logs
-func WithError(err error) *logrus.Entry {
-	return logger.WithField("error", err)
+func WithError(err error) *slog.Logger {
+	return slog.Default().With(slog.Any("error", err))
 }
 
 func SubscribeToErrorChan(errors <-chan error) {
 	go func() {
 		for {
 			e := <-errors
-			logger.Error(e.Error())
+			Error(e.Error())
 		}
 	}()
 }
+
+// ConnectionLogger is a *slog.Logger pre-decorated with the connection's id
+// and remote address, so every log line from a Connection is automatically
+// correlated.
+type ConnectionLogger struct {
+	*slog.Logger
+}
+
+// BuildConnectionLogger returns a ConnectionLogger for the connection with the
+// given id and remote address.
+func BuildConnectionLogger(id, remoteAddr string) *ConnectionLogger {
+	return &ConnectionLogger{
+		Logger: slog.Default().With(slog.Group("conn", "id", id, "remote_addr", remoteAddr)),
+	}
+}
+
+// WithError returns a logger with the supplied error added to the logs
+func (c *ConnectionLogger) WithError(err error) *slog.Logger {
+	return c.Logger.With(slog.Any("error", err))
+}
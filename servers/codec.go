@@ -0,0 +1,136 @@
+package servers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// defaultMaxFrameSize bounds LengthPrefixedCodec reads when a server doesn't
+// configure one explicitly, so a malicious length prefix can't force an
+// unbounded allocation.
+const defaultMaxFrameSize = 64 * 1024
+
+// Codec controls how a Connection frames messages on the wire: how a single
+// message is read off a *bufio.Reader, how one is written, and how a tick's
+// worth of buffered updates is encoded into the payload Flush writes to the
+// wire as-is, without passing it through WriteFrame.
+type Codec interface {
+	ReadFrame(r *bufio.Reader) ([]byte, error)
+	WriteFrame(w io.Writer, p []byte) error
+	EncodeUpdate(tick uint32, updates []byte) []byte
+}
+
+// encodeTickUpdate builds the tick-framed update payload shared by every
+// Codec: a 4-byte little-endian tick, followed by the buffered updates,
+// followed by a trailing zero byte terminator.
+func encodeTickUpdate(tick uint32, updates []byte) []byte {
+	b := make([]byte, 4, 4+len(updates)+1)
+	binary.LittleEndian.PutUint32(b, tick)
+	b = append(b, updates...)
+	b = append(b, 0)
+	return b
+}
+
+// LengthPrefixedCodec frames each message with a fixed-width length prefix,
+// either 2 or 4 bytes, in the given byte order. MaxFrameSize, when non-zero,
+// rejects any incoming frame whose declared length exceeds it, guarding
+// against a hostile or corrupt prefix forcing an oversized allocation.
+type LengthPrefixedCodec struct {
+	PrefixBytes  int
+	ByteOrder    binary.ByteOrder
+	MaxFrameSize int
+}
+
+func (c *LengthPrefixedCodec) byteOrder() binary.ByteOrder {
+	if c.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return c.ByteOrder
+}
+
+func (c *LengthPrefixedCodec) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	prefix := make([]byte, c.PrefixBytes)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+
+	var length uint32
+	switch c.PrefixBytes {
+	case 2:
+		length = uint32(c.byteOrder().Uint16(prefix))
+	case 4:
+		length = c.byteOrder().Uint32(prefix)
+	default:
+		return nil, fmt.Errorf("servers: LengthPrefixedCodec: unsupported PrefixBytes %d", c.PrefixBytes)
+	}
+
+	if c.MaxFrameSize > 0 && int(length) > c.MaxFrameSize {
+		return nil, fmt.Errorf("servers: LengthPrefixedCodec: frame size %d exceeds MaxFrameSize %d", length, c.MaxFrameSize)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (c *LengthPrefixedCodec) WriteFrame(w io.Writer, p []byte) error {
+	prefix := make([]byte, c.PrefixBytes)
+	switch c.PrefixBytes {
+	case 2:
+		c.byteOrder().PutUint16(prefix, uint16(len(p)))
+	case 4:
+		c.byteOrder().PutUint32(prefix, uint32(len(p)))
+	default:
+		return fmt.Errorf("servers: LengthPrefixedCodec: unsupported PrefixBytes %d", c.PrefixBytes)
+	}
+
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+func (c *LengthPrefixedCodec) EncodeUpdate(tick uint32, updates []byte) []byte {
+	return encodeTickUpdate(tick, updates)
+}
+
+// NewlineDelimitedCodec frames each message as a single line, terminated by
+// '\n'. It suits line-oriented text protocols and lets a message be produced
+// or inspected with plain netcat, matching the bufio.Scanner-per-line pattern
+// simple TCP listeners already use elsewhere.
+type NewlineDelimitedCodec struct{}
+
+func (NewlineDelimitedCodec) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\n"), nil
+}
+
+func (NewlineDelimitedCodec) WriteFrame(w io.Writer, p []byte) error {
+	if _, err := w.Write(p); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte{'\n'})
+	return err
+}
+
+func (NewlineDelimitedCodec) EncodeUpdate(tick uint32, updates []byte) []byte {
+	return encodeTickUpdate(tick, updates)
+}
+
+// DefaultCodec matches the read framing TCPConnection used before Codec
+// existed: a 2-byte little-endian length prefix, capped at defaultMaxFrameSize.
+var DefaultCodec Codec = &LengthPrefixedCodec{
+	PrefixBytes:  2,
+	ByteOrder:    binary.LittleEndian,
+	MaxFrameSize: defaultMaxFrameSize,
+}
@@ -0,0 +1,72 @@
+package servers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestLengthPrefixedCodecRoundTrip(t *testing.T) {
+	cases := []struct {
+		name  string
+		codec *LengthPrefixedCodec
+	}{
+		{"2-byte-le", &LengthPrefixedCodec{PrefixBytes: 2, ByteOrder: binary.LittleEndian}},
+		{"4-byte-be", &LengthPrefixedCodec{PrefixBytes: 4, ByteOrder: binary.BigEndian}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			payload := []byte("hello, world")
+
+			if err := tc.codec.WriteFrame(&buf, payload); err != nil {
+				t.Fatalf("WriteFrame: %v", err)
+			}
+
+			got, err := tc.codec.ReadFrame(bufio.NewReader(&buf))
+			if err != nil {
+				t.Fatalf("ReadFrame: %v", err)
+			}
+
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("got %q, want %q", got, payload)
+			}
+		})
+	}
+}
+
+func TestLengthPrefixedCodecMaxFrameSize(t *testing.T) {
+	codec := &LengthPrefixedCodec{PrefixBytes: 2, ByteOrder: binary.LittleEndian, MaxFrameSize: 4}
+
+	var buf bytes.Buffer
+	prefix := make([]byte, 2)
+	binary.LittleEndian.PutUint16(prefix, 10)
+	buf.Write(prefix)
+	buf.WriteString("0123456789")
+
+	if _, err := codec.ReadFrame(bufio.NewReader(&buf)); err == nil {
+		t.Fatal("expected a frame declaring a length over MaxFrameSize to be rejected")
+	}
+}
+
+func TestNewlineDelimitedCodecRoundTrip(t *testing.T) {
+	codec := NewlineDelimitedCodec{}
+
+	var buf bytes.Buffer
+	payload := []byte("hello")
+
+	if err := codec.WriteFrame(&buf, payload); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+
+	got, err := codec.ReadFrame(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("got %q, want %q", got, payload)
+	}
+}
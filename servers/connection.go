@@ -0,0 +1,148 @@
+package servers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/soupstoregames/go-core/logging"
+)
+
+// defaultBufferCap bounds a connection's pending update buffer when no
+// explicit BufferCap is set, so a slow client can't blow up memory while
+// BufferUpdate keeps getting called faster than Flush drains it.
+const defaultBufferCap = 1 << 20 // 1 MiB
+
+// connectionCore holds the identity, lifecycle and buffered-update logic
+// shared by every Connection implementation. TCPConnection and
+// WebSocketConnection embed it and only implement their transport-specific
+// WriteMessage, ReadMessage and Close.
+type connectionCore struct {
+	*logging.ConnectionLogger
+	Closed bool
+
+	// BufferCap caps the number of pending update bytes BufferUpdate will
+	// accept before rejecting writes. Defaults to defaultBufferCap.
+	BufferCap int
+
+	// OnBufferOverflow, if set, is called every time BufferUpdate rejects a
+	// write because BufferCap would be exceeded. Wire it to a metrics
+	// counter to detect clients the server can't keep up with.
+	OnBufferOverflow func()
+
+	// OnFlush, if set, is called after every successful Flush with the
+	// number of bytes written and how long the write took.
+	OnFlush func(bytes int, dur time.Duration)
+
+	id             string
+	closeFunctions []func()
+	ctx            context.Context
+	cancel         context.CancelFunc
+
+	mu sync.Mutex
+	// updates is a capped linear buffer that BufferUpdate appends to and
+	// Flush drains and resets each tick. It's a bound on pending bytes, not a
+	// ring buffer: nothing ever reads a partial window of it, so there's no
+	// wraparound state to track.
+	updates bytes.Buffer
+}
+
+func newConnectionCore(remoteAddr string) connectionCore {
+	id := uuid.New().String()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return connectionCore{
+		ConnectionLogger: logging.BuildConnectionLogger(id, remoteAddr),
+		BufferCap:        defaultBufferCap,
+		id:               id,
+		ctx:              ctx,
+		cancel:           cancel,
+	}
+}
+
+func (c *connectionCore) ID() string {
+	return c.id
+}
+
+// Context returns a context that is cancelled when the connection is closed,
+// so goroutines reading via ReadMessage can unblock by watching Done.
+func (c *connectionCore) Context() context.Context {
+	return c.ctx
+}
+
+func (c *connectionCore) OnClose(f func()) {
+	c.closeFunctions = append(c.closeFunctions, f)
+}
+
+func (c *connectionCore) Logger() *logging.ConnectionLogger {
+	return c.ConnectionLogger
+}
+
+// markClosed runs the shared close bookkeeping and reports whether this call
+// actually closed the connection, so a transport-specific Close can skip its
+// own conn.Close() on a second call.
+func (c *connectionCore) markClosed() bool {
+	if c.Closed {
+		return false
+	}
+
+	c.Info("Closing connection")
+	c.Closed = true
+	c.cancel()
+	for _, f := range c.closeFunctions {
+		f()
+	}
+	return true
+}
+
+// BufferUpdate appends s to the connection's pending update buffer. It
+// returns an error without buffering anything if doing so would exceed
+// BufferCap, giving upstream game/simulation code a signal to shed load
+// instead of letting a slow client grow the buffer without bound.
+func (c *connectionCore) BufferUpdate(s []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.BufferCap > 0 && c.updates.Len()+len(s) > c.BufferCap {
+		if c.OnBufferOverflow != nil {
+			c.OnBufferOverflow()
+		}
+		return fmt.Errorf("servers: update buffer full (cap %d bytes)", c.BufferCap)
+	}
+
+	c.updates.Write(s)
+	return nil
+}
+
+// flush drains the pending update buffer, encodes it with encode, and writes
+// it with write. It's shared by TCPConnection and WebSocketConnection, which
+// differ only in how a tick's payload is encoded and written to the wire.
+func (c *connectionCore) flush(tick uint32, encode func(tick uint32, updates []byte) []byte, write func([]byte) error, closeConn func() error) {
+	c.mu.Lock()
+	if c.updates.Len() == 0 {
+		c.mu.Unlock()
+		return
+	}
+	updates := append([]byte(nil), c.updates.Bytes()...)
+	c.updates.Reset()
+	c.mu.Unlock()
+
+	start := time.Now()
+	b := encode(tick, updates)
+
+	if err := write(b); err != nil {
+		if c.Closed {
+			return
+		}
+		c.WithError(err).Error("Failed to write updates")
+		closeConn()
+		return
+	}
+
+	if c.OnFlush != nil {
+		c.OnFlush(len(b), time.Since(start))
+	}
+}
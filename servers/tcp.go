@@ -2,170 +2,272 @@ package servers
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/binary"
-	"github.com/google/uuid"
+	"context"
+	"crypto/tls"
 	"github.com/soupstoregames/go-core/logging"
 	"net"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Connection is a transport-agnostic client connection. TCPConnection and
+// WebSocketConnection both implement it so servers can push either onto the
+// same Connections channel and downstream code can treat them identically.
 type Connection interface {
+	ID() string
 	WriteMessage(p []byte) (err error)
-	BufferUpdate(s []byte)
+	BufferUpdate(s []byte) error
 	Flush(tick uint32)
+	ReadMessage() ([]byte, error)
+	Close() error
+	OnClose(f func())
+	Context() context.Context
 	Logger() *logging.ConnectionLogger
 }
 
 type Server struct {
-	Connections chan *TCPConnection
+	Connections chan Connection
+
+	listener   net.Listener
+	addr       string
+	codec      Codec
+	tlsConfig  *tls.Config
+	wg         sync.WaitGroup
+	shutdownCh chan struct{}
+}
+
+// ServerOption configures a Server created by NewTCPServer.
+type ServerOption func(*Server)
+
+// WithCodec sets the Codec used to frame messages on every accepted
+// connection. It defaults to DefaultCodec.
+func WithCodec(codec Codec) ServerOption {
+	return func(s *Server) {
+		s.codec = codec
+	}
+}
 
-	listener net.Listener
-	addr     string
-	stopping bool
+// WithMaxFrameSize sets the MaxFrameSize guard on the server's codec, if it
+// is a *LengthPrefixedCodec. Apply it after WithCodec if both are used.
+func WithMaxFrameSize(n int) ServerOption {
+	return func(s *Server) {
+		if lp, ok := s.codec.(*LengthPrefixedCodec); ok {
+			cp := *lp
+			cp.MaxFrameSize = n
+			s.codec = &cp
+		}
+	}
 }
 
-func NewTCPServer(addr string) *Server {
-	return &Server{
+// WithTLS makes the server listen with TLS using cfg instead of plain TCP.
+func WithTLS(cfg *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tlsConfig = cfg
+	}
+}
+
+func NewTCPServer(addr string, opts ...ServerOption) *Server {
+	s := &Server{
 		addr:        addr,
-		Connections: make(chan *TCPConnection),
+		Connections: make(chan Connection),
+		codec:       DefaultCodec,
+		shutdownCh:  make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	return s
 }
 
-func (t *Server) Start() error {
+// Start listens on the server's address and calls Serve. It blocks until ctx
+// is cancelled or the listener is closed by Shutdown.
+func (t *Server) Start(ctx context.Context) error {
+	var l net.Listener
 	var err error
 
-	if t.listener, err = net.Listen("tcp", t.addr); err != nil {
+	if t.tlsConfig != nil {
+		l, err = tls.Listen("tcp", t.addr, t.tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", t.addr)
+	}
+	if err != nil {
 		return err
 	}
 
+	return t.Serve(ctx, l)
+}
+
+// Serve accepts connections on l and pushes them onto Connections until ctx
+// is cancelled or Shutdown is called.
+func (t *Server) Serve(ctx context.Context, l net.Listener) error {
+	t.listener = l
+
 	logging.Info("TCP Server listening on " + t.addr)
 
+	go func() {
+		<-ctx.Done()
+		t.listener.Close()
+	}()
+
 	for {
-		if t.stopping {
-			break
-		}
-		// Listen for an incoming connection.
-		conn, err := t.listener.Accept()
+		conn, err := l.Accept()
 		if err != nil {
 			// net.errClosing is not exported so this
 			if strings.Contains(err.Error(), "use of closed network connection") {
-				break
+				return nil
 			}
 			logging.Error(err.Error())
-		}
-
-		if t.stopping {
-			conn.Close()
-			break
+			continue
 		}
 
 		logging.Debug("Client connected: " + conn.RemoteAddr().String())
 
-		t.Connections <- NewTCPConnection(conn)
+		c := NewTCPConnection(conn, t.codec)
+		t.wg.Add(1)
+		c.OnClose(func() { t.wg.Done() })
+
+		select {
+		case t.Connections <- c:
+		case <-ctx.Done():
+			c.Close()
+			return nil
+		case <-t.shutdownCh:
+			// Shutdown is draining us: unblock immediately instead of
+			// holding this connection (and t.wg) open until ctx's deadline.
+			c.Close()
+			return nil
+		}
 	}
-
-	return nil
 }
 
-func (t *Server) Stop() {
+// Shutdown closes the listener and signals Serve to stop offering
+// connections on Connections, then waits for every accepted connection to
+// close, up to ctx's deadline. Connections is only closed once that drain
+// completes — if ctx expires first, Shutdown returns ctx.Err() without
+// closing Connections, since a still-blocked Serve send could otherwise
+// panic on a send to a closed channel.
+func (t *Server) Shutdown(ctx context.Context) error {
 	logging.Info("Stopping TCP Server")
-	t.stopping = true
-	close(t.Connections)
+
+	close(t.shutdownCh)
+
+	if t.listener != nil {
+		t.listener.Close()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		close(t.Connections)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 type TCPConnection struct {
-	*logging.ConnectionLogger
-	Closed bool
+	connectionCore
 
-	conn           net.Conn
-	reader         *bufio.Reader
-	id             string
-	closeFunctions []func()
-	updates        bytes.Buffer
+	conn   net.Conn
+	reader *bufio.Reader
+	codec  Codec
 }
 
-func NewTCPConnection(c net.Conn) *TCPConnection {
-	id := uuid.New().String()
+// NewTCPConnection wraps c using codec to frame messages. A nil codec falls
+// back to DefaultCodec.
+func NewTCPConnection(c net.Conn, codec Codec) *TCPConnection {
+	if codec == nil {
+		codec = DefaultCodec
+	}
+
 	conn := &TCPConnection{
-		ConnectionLogger: logging.BuildConnectionLogger(id),
-		conn:             c,
-		reader:           bufio.NewReader(c),
-		id:               id,
+		connectionCore: newConnectionCore(c.RemoteAddr().String()),
+		conn:           c,
+		reader:         bufio.NewReader(c),
+		codec:          codec,
 	}
 
+	go func() {
+		<-conn.ctx.Done()
+		conn.conn.SetReadDeadline(time.Now())
+	}()
+
+	return conn
+}
+
+// NewTCPConnectionWithFlusher wraps c like NewTCPConnection, then starts a
+// background goroutine that calls Flush on the given interval so callers
+// don't have to drive ticks themselves. The goroutine stops when the
+// connection is closed.
+func NewTCPConnectionWithFlusher(c net.Conn, codec Codec, interval time.Duration) *TCPConnection {
+	conn := NewTCPConnection(c, codec)
+	conn.startFlusher(interval)
 	return conn
 }
 
-func (c *TCPConnection) ID() string {
-	return c.id
+func (c *TCPConnection) startFlusher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var tick uint32
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				tick++
+				c.Flush(tick)
+			}
+		}
+	}()
 }
 
 func (c *TCPConnection) Close() error {
-	if c.Closed {
+	if !c.markClosed() {
 		return nil
 	}
-
-	c.Info("Closing connection")
-	c.Closed = true
-	for _, f := range c.closeFunctions {
-		f()
-	}
 	return c.conn.Close()
 }
 
-func (c *TCPConnection) OnClose(f func()) {
-	c.closeFunctions = append(c.closeFunctions, f)
+// WriteMessage sends p as a single codec-framed message, e.g. a
+// client-addressed request or response. It is distinct from the raw write
+// Flush uses, since EncodeUpdate already produces a self-delimited payload
+// that must not be framed a second time.
+func (c *TCPConnection) WriteMessage(p []byte) error {
+	return c.codec.WriteFrame(c.conn, p)
 }
 
-func (c *TCPConnection) WriteMessage(p []byte) error {
+// writeRaw writes p straight to the underlying connection with no framing.
+// Flush uses it because the codec's EncodeUpdate already returns a complete,
+// self-delimited wire payload (tick + updates + terminator) — running that
+// through WriteFrame as well would incorrectly prepend a second layer of
+// framing (e.g. a length prefix) that no client expects.
+func (c *TCPConnection) writeRaw(p []byte) error {
 	_, err := c.conn.Write(p)
 	return err
 }
 
 func (c *TCPConnection) ReadMessage() ([]byte, error) {
-	length := make([]byte, 2)
-	if _, err := c.reader.Read(length); err != nil {
+	body, err := c.codec.ReadFrame(c.reader)
+	if err != nil {
 		if c.Closed {
 			return []byte{}, nil
 		}
 		return []byte{}, err
 	}
 
-	body := make([]byte, binary.LittleEndian.Uint16(length))
-	if _, err := c.reader.Read(body); err != nil {
-		return []byte{}, err
-	}
-
 	return body, nil
 }
 
-func (c *TCPConnection) BufferUpdate(s []byte) {
-	c.updates.Write(s)
-}
-
 func (c *TCPConnection) Flush(tick uint32) {
-	if c.updates.Len() == 0 {
-		return
-	}
-
-	b := make([]byte, 4, 1024)
-
-	binary.LittleEndian.PutUint32(b[:], tick)
-	b = append(b, c.updates.Bytes()...)
-	b = append(b, 0)
-
-	if err := c.WriteMessage(b); err != nil {
-		if c.Closed {
-			return
-		}
-		c.ConnectionLogger.WithError(err).Error("Failed to write updates")
-		c.Close()
-	}
-
-	c.updates.Reset()
-}
-
-func (c *TCPConnection) Logger() *logging.ConnectionLogger {
-	return c.ConnectionLogger
+	c.flush(tick, c.codec.EncodeUpdate, c.writeRaw, c.Close)
 }
@@ -0,0 +1,121 @@
+package servers
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPConnectionFlushWritesRawPayload(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := NewTCPConnection(server, DefaultCodec)
+	defer conn.Close()
+
+	payload := []byte("hello")
+	if err := conn.BufferUpdate(payload); err != nil {
+		t.Fatalf("BufferUpdate: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		conn.Flush(1)
+		close(done)
+	}()
+
+	got := make([]byte, 4+len(payload)+1)
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	<-done
+
+	// Flush must write the tick straight onto the wire: no length prefix
+	// (or any other framing) ahead of it, since EncodeUpdate's result is
+	// already the complete, self-delimited payload.
+	if gotTick := binary.LittleEndian.Uint32(got[:4]); gotTick != 1 {
+		t.Fatalf("got tick %d, want 1 (did Flush prepend extra framing?)", gotTick)
+	}
+	if string(got[4:4+len(payload)]) != string(payload) {
+		t.Fatalf("got payload %q, want %q", got[4:4+len(payload)], payload)
+	}
+	if got[len(got)-1] != 0 {
+		t.Fatalf("got trailing byte %d, want 0 terminator", got[len(got)-1])
+	}
+}
+
+func TestServerShutdownDrainsConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	srv := NewTCPServer(l.Addr().String())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(context.Background(), l) }()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	c := <-srv.Connections
+	c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if _, ok := <-srv.Connections; ok {
+		t.Fatal("expected Connections to be closed after a drained Shutdown")
+	}
+
+	if err := <-serveErr; err != nil {
+		t.Fatalf("Serve returned an error: %v", err)
+	}
+}
+
+// TestServerShutdownTimeoutDoesNotCloseConnections reproduces the panic a
+// timed-out Shutdown used to cause: it must not close Connections while
+// Serve's dispatch select might still be blocked trying to send on it.
+func TestServerShutdownTimeoutDoesNotCloseConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	srv := NewTCPServer(l.Addr().String())
+	go srv.Serve(context.Background(), l)
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	// Pull the connection off Connections but never close it, so t.wg still
+	// counts it as in-flight when Shutdown times out.
+	<-srv.Connections
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Shutdown closed Connections despite timing out: %v", r)
+		}
+	}()
+	close(srv.Connections)
+}
@@ -0,0 +1,228 @@
+package servers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/soupstoregames/go-core/logging"
+)
+
+const (
+	wsWriteWait = 10 * time.Second
+	wsPongWait  = 60 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// WebSocketServer accepts websocket connections on a single HTTP path and
+// pushes them onto Connections alongside whatever other transports a caller
+// is running, since Connections is typed as chan Connection.
+type WebSocketServer struct {
+	Connections chan Connection
+
+	addr         string
+	path         string
+	pingInterval time.Duration
+
+	httpServer *http.Server
+	wg         sync.WaitGroup
+	shutdownCh chan struct{}
+}
+
+// NewWebSocketServer creates a WebSocketServer that upgrades requests on path
+// and pings clients every 30 seconds to keep the connection alive.
+func NewWebSocketServer(addr, path string) *WebSocketServer {
+	return &WebSocketServer{
+		Connections:  make(chan Connection),
+		addr:         addr,
+		path:         path,
+		pingInterval: 30 * time.Second,
+		shutdownCh:   make(chan struct{}),
+	}
+}
+
+// WithPingInterval overrides the keepalive ping interval.
+func (s *WebSocketServer) WithPingInterval(interval time.Duration) *WebSocketServer {
+	s.pingInterval = interval
+	return s
+}
+
+// Start listens and serves until ctx is cancelled or Shutdown is called.
+func (s *WebSocketServer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.path, s.handleUpgrade)
+
+	s.httpServer = &http.Server{
+		Addr:    s.addr,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.httpServer.Close()
+	}()
+
+	logging.Info("WebSocket Server listening on " + s.addr)
+
+	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// Shutdown stops the HTTP server and signals handleUpgrade to stop offering
+// connections on Connections, then waits for every upgraded connection to
+// close, up to ctx's deadline. Connections is only closed once that drain
+// completes — if ctx expires first, Shutdown returns its error without
+// closing Connections, since a still-blocked handleUpgrade send could
+// otherwise panic on a send to a closed channel.
+//
+// This mirrors Server.Shutdown in tcp.go: http.Server.Shutdown can't help
+// here because upgraded connections are hijacked out of its own connection
+// tracking the moment upgrader.Upgrade succeeds, so it has no visibility
+// into handleUpgrade's blocking send on Connections.
+func (s *WebSocketServer) Shutdown(ctx context.Context) error {
+	logging.Info("Stopping WebSocket Server")
+
+	close(s.shutdownCh)
+
+	var err error
+	if s.httpServer != nil {
+		err = s.httpServer.Shutdown(ctx)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		close(s.Connections)
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *WebSocketServer) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logging.WithError(err).Error("Failed to upgrade websocket connection")
+		return
+	}
+
+	logging.Debug("Client connected: " + conn.RemoteAddr().String())
+
+	c := NewWebSocketConnection(conn, s.pingInterval)
+	s.wg.Add(1)
+	c.OnClose(func() { s.wg.Done() })
+
+	select {
+	case s.Connections <- c:
+	case <-s.shutdownCh:
+		// Shutdown is draining us: unblock immediately instead of holding
+		// this connection (and s.wg) open until ctx's deadline.
+		c.Close()
+	}
+}
+
+// WebSocketConnection implements Connection over a gorilla/websocket
+// connection. It uses the same tick + buffered updates framing as
+// TCPConnection, but Flush sends it as a single binary WebSocket frame
+// instead of relying on a length prefix.
+type WebSocketConnection struct {
+	connectionCore
+
+	conn *websocket.Conn
+
+	// writeMu serializes every conn.WriteMessage call. gorilla/websocket
+	// requires a single writer at a time; without this, the keepAlive ping
+	// and an application Flush can race on the same connection and panic.
+	writeMu sync.Mutex
+}
+
+// NewWebSocketConnection wraps an upgraded websocket connection and starts a
+// background ping/pong keepalive goroutine on the given interval.
+func NewWebSocketConnection(c *websocket.Conn, pingInterval time.Duration) *WebSocketConnection {
+	conn := &WebSocketConnection{
+		connectionCore: newConnectionCore(c.RemoteAddr().String()),
+		conn:           c,
+	}
+
+	c.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.SetPongHandler(func(string) error {
+		c.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	go conn.keepAlive(conn.ctx, pingInterval)
+	go func() {
+		<-conn.ctx.Done()
+		c.SetReadDeadline(time.Now())
+	}()
+
+	return conn
+}
+
+func (c *WebSocketConnection) keepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.writeMu.Lock()
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := c.conn.WriteMessage(websocket.PingMessage, nil)
+			c.writeMu.Unlock()
+
+			if err != nil {
+				c.ConnectionLogger.WithError(err).Error("Failed to ping client")
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *WebSocketConnection) Close() error {
+	if !c.markClosed() {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+func (c *WebSocketConnection) WriteMessage(p []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteMessage(websocket.BinaryMessage, p)
+}
+
+func (c *WebSocketConnection) ReadMessage() ([]byte, error) {
+	_, body, err := c.conn.ReadMessage()
+	if err != nil {
+		if c.Closed {
+			return []byte{}, nil
+		}
+		return []byte{}, err
+	}
+
+	return body, nil
+}
+
+func (c *WebSocketConnection) Flush(tick uint32) {
+	c.flush(tick, encodeTickUpdate, c.WriteMessage, c.Close)
+}
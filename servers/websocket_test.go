@@ -0,0 +1,119 @@
+package servers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestWebSocketConnectionConcurrentFlushAndKeepAlive exercises Flush and the
+// ping/pong keepalive goroutine writing to the same connection at once. Run
+// with -race: gorilla/websocket panics on a detected concurrent write, so a
+// clean run here is what proves writeMu actually serializes them.
+func TestWebSocketConnectionConcurrentFlushAndKeepAlive(t *testing.T) {
+	upgraded := make(chan *WebSocketConnection, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		upgraded <- NewWebSocketConnection(c, 2*time.Millisecond)
+	}))
+	defer srv.Close()
+
+	wsURL := "ws" + srv.URL[len("http"):]
+	client, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn := <-upgraded
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := conn.BufferUpdate([]byte("x")); err != nil {
+				t.Errorf("BufferUpdate: %v", err)
+				return
+			}
+			conn.Flush(uint32(i))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for concurrent Flush/keepAlive loop")
+	}
+}
+
+// TestWebSocketServerShutdownTimeoutDoesNotCloseConnections reproduces the
+// panic a timed-out Shutdown used to cause: an upgraded connection is
+// hijacked out of http.Server's own tracking, so handleUpgrade's send on
+// Connections is invisible to http.Server.Shutdown's drain. Shutdown must
+// not close Connections while that send might still be blocked.
+func TestWebSocketServerShutdownTimeoutDoesNotCloseConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	srv := NewWebSocketServer(addr, "/ws")
+	go srv.Start(context.Background())
+
+	wsURL := "ws://" + addr + "/ws"
+
+	var client *websocket.Conn
+	var dialErr error
+	for i := 0; i < 100; i++ {
+		client, _, dialErr = websocket.DefaultDialer.Dial(wsURL, nil)
+		if dialErr == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dialErr != nil {
+		t.Fatalf("dial: %v", dialErr)
+	}
+	defer client.Close()
+
+	// Pull the upgraded connection off Connections but never close it, so
+	// s.wg still counts it as in-flight when Shutdown is called.
+	<-srv.Connections
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("Shutdown closed Connections despite timing out: %v", r)
+		}
+	}()
+	close(srv.Connections)
+}